@@ -5,10 +5,14 @@ import (
 	"fmt"
 	"io"
 	"io/fs"
+	"os"
 	"path/filepath"
+	"reflect"
 	"regexp"
 	"strconv"
 	"strings"
+	"text/template"
+	"time"
 	"unicode"
 
 	"github.com/Xuanwo/go-locale"
@@ -75,6 +79,475 @@ func runeSliceWidthLastRange(rs []rune, maxWidth int) []rune {
 	return rs
 }
 
+const ansiEsc = '\x1b'
+
+// Appended whenever truncation cuts a slice inside a still-active SGR
+// (color/style) escape region, so the color does not bleed into whatever is
+// drawn in the next UI cell.
+var ansiResetSeq = []rune("\x1b[0m")
+
+// This function returns the length, in runes, of the ANSI escape sequence
+// starting at rs[i], or 0 if rs[i] is not an ESC rune. It understands CSI
+// sequences (`ESC [ params... final-byte`), OSC sequences (`ESC ] ... BEL`
+// or `ESC ] ... ST`), and the shorter two-rune `ESC x` forms. A lone ESC at
+// the end of rs counts as a one-rune sequence, and a CSI or OSC sequence cut
+// off by the end of rs runs to the end.
+func escSeqLen(rs []rune, i int) int {
+	if i >= len(rs) || rs[i] != ansiEsc {
+		return 0
+	}
+	if i+1 >= len(rs) {
+		return 1
+	}
+
+	switch rs[i+1] {
+	case '[':
+		j := i + 2
+		for j < len(rs) && (rs[j] < 0x40 || rs[j] > 0x7e) {
+			j++
+		}
+		if j < len(rs) {
+			j++
+		}
+		return j - i
+	case ']':
+		j := i + 2
+		for j < len(rs) {
+			if rs[j] == '\a' {
+				j++
+				break
+			}
+			if rs[j] == ansiEsc && j+1 < len(rs) && rs[j+1] == '\\' {
+				j += 2
+				break
+			}
+			j++
+		}
+		return j - i
+	default:
+		return 2
+	}
+}
+
+// This function reports whether rs[beg:end] is a CSI SGR sequence, i.e. one
+// of the form `ESC [ ... m` that sets colors or text attributes.
+func escIsSGR(rs []rune, beg, end int) bool {
+	return end-beg >= 3 && rs[beg+1] == '[' && rs[end-1] == 'm'
+}
+
+// This function reports whether rs[beg:end] is an SGR sequence that resets
+// all attributes, i.e. `ESC[0m` or the equivalent bare `ESC[m`.
+func escIsSGRReset(rs []rune, beg, end int) bool {
+	if !escIsSGR(rs, beg, end) {
+		return false
+	}
+	params := string(rs[beg+2 : end-1])
+	return params == "" || params == "0"
+}
+
+// escAwareWidth is like runeSliceWidth but skips over ANSI CSI/OSC escape
+// sequences instead of counting their bytes as display width. This is needed
+// to correctly measure colorized previewer output, where escape sequences
+// would otherwise be counted as printable characters.
+func escAwareWidth(rs []rune) int {
+	w := 0
+	for i := 0; i < len(rs); {
+		if rs[i] == ansiEsc {
+			if n := escSeqLen(rs, i); n > 0 {
+				i += n
+				continue
+			}
+		}
+		w += runewidth.RuneWidth(rs[i])
+		i++
+	}
+	return w
+}
+
+// escAwareSliceRange is like runeSliceWidthRange but ignores escape
+// sequences when computing display width, while keeping any that fall
+// inside the kept range intact in the result so their effect (e.g. color)
+// is preserved. If beg falls inside a region already colored by an SGR
+// sequence before it, that sequence is re-emitted at the front of the
+// result so the color isn't lost along with the runes before beg. If the
+// cut point lands inside a still-active SGR region, a synthetic
+// ansiResetSeq is appended so color does not bleed past the cut.
+func escAwareSliceRange(rs []rune, beg, end int) []rune {
+	if beg == end {
+		return []rune{}
+	}
+
+	curr := 0
+	b := 0
+	foundb := false
+	var active []rune
+	var prefix []rune
+
+	for i := 0; i < len(rs); {
+		if rs[i] == ansiEsc {
+			if n := escSeqLen(rs, i); n > 0 {
+				if escIsSGR(rs, i, i+n) {
+					if escIsSGRReset(rs, i, i+n) {
+						active = nil
+					} else {
+						active = append([]rune{}, rs[i:i+n]...)
+					}
+				}
+				i += n
+				continue
+			}
+		}
+
+		w := runewidth.RuneWidth(rs[i])
+		if curr >= beg && !foundb {
+			b = i
+			foundb = true
+			prefix = append([]rune{}, active...)
+		}
+		if curr == end || curr+w > end {
+			out := rs[b:i]
+			if len(prefix) > 0 {
+				out = append(append([]rune{}, prefix...), out...)
+			}
+			if active != nil {
+				out = append(append([]rune{}, out...), ansiResetSeq...)
+			}
+			return out
+		}
+		curr += w
+		i++
+	}
+
+	if !foundb {
+		return []rune{}
+	}
+	out := rs[b:]
+	if len(prefix) > 0 {
+		out = append(append([]rune{}, prefix...), out...)
+	}
+	return out
+}
+
+// escAwareTruncate returns the leading runes of rs whose visible width fits
+// within maxWidth, the same way escAwareSliceRange(rs, 0, maxWidth) would.
+//
+// Meant to be called from the preview pane, the statusline ruler and the
+// completion menu wherever they currently truncate by rune count instead of
+// escape-aware width; that wiring lives outside this file and isn't part of
+// this change.
+func escAwareTruncate(rs []rune, maxWidth int) []rune {
+	return escAwareSliceRange(rs, 0, maxWidth)
+}
+
+// escAwareSplitWidth splits rs into a leading head whose visible width fits
+// within maxWidth and the remaining tail, cutting before the first visible
+// rune that would take the width past maxWidth (unlike escAwareSliceRange,
+// zero-width runes right at the cut stay in head). The unconsumed tail is
+// returned so callers like wrapLines can keep consuming it across several
+// output lines.
+func escAwareSplitWidth(rs []rune, maxWidth int) (head, tail []rune) {
+	curr := 0
+	colored := false
+
+	i := 0
+	for i < len(rs) {
+		if rs[i] == ansiEsc {
+			if n := escSeqLen(rs, i); n > 0 {
+				if escIsSGR(rs, i, i+n) {
+					colored = !escIsSGRReset(rs, i, i+n)
+				}
+				i += n
+				continue
+			}
+		}
+
+		w := runewidth.RuneWidth(rs[i])
+		if curr+w > maxWidth {
+			break
+		}
+		curr += w
+		i++
+	}
+
+	head = rs[:i]
+	if colored && i < len(rs) {
+		head = append(append([]rune{}, head...), ansiResetSeq...)
+	}
+	return head, rs[i:]
+}
+
+// updateSGRState scans rs for SGR escape sequences in order and returns the
+// resulting "active" sequence: nil once a reset is seen, or the bytes of the
+// last non-reset SGR sequence found. It is used to track, across a wrapped
+// line, which color/style escape (if any) needs to be re-emitted at the
+// start of the next output line.
+func updateSGRState(state, rs []rune) []rune {
+	for i := 0; i < len(rs); {
+		if rs[i] != ansiEsc {
+			i++
+			continue
+		}
+		n := escSeqLen(rs, i)
+		if n == 0 {
+			i++
+			continue
+		}
+		if escIsSGR(rs, i, i+n) {
+			if escIsSGRReset(rs, i, i+n) {
+				state = nil
+			} else {
+				state = append([]rune{}, rs[i:i+n]...)
+			}
+		}
+		i += n
+	}
+	return state
+}
+
+// wrapToken is a single run of either whitespace or non-whitespace runes, as
+// produced by tokenizeWrapTokens. Embedded ANSI escape sequences ride along
+// inside whichever token they physically fall in.
+type wrapToken struct {
+	text  []rune
+	space bool
+}
+
+// tokenizeWrapTokens splits rs into alternating whitespace/non-whitespace
+// runs, further splitting each non-whitespace run at the word boundaries
+// matched by reWordEnd so that wrapLines has more candidate break points
+// than just the gaps between words (e.g. inside "foo/bar/baz").
+func tokenizeWrapTokens(rs []rune) []wrapToken {
+	var toks []wrapToken
+
+	i := 0
+	for i < len(rs) {
+		start := i
+
+		j := i
+		for j < len(rs) && rs[j] == ansiEsc {
+			j += escSeqLen(rs, j)
+		}
+		isSpace := j < len(rs) && unicode.IsSpace(rs[j])
+
+		for i < len(rs) {
+			if rs[i] == ansiEsc {
+				i += escSeqLen(rs, i)
+				continue
+			}
+			if unicode.IsSpace(rs[i]) != isSpace {
+				break
+			}
+			i++
+		}
+
+		tok := rs[start:i]
+		if isSpace {
+			toks = append(toks, wrapToken{text: tok, space: true})
+			continue
+		}
+
+		for _, part := range splitWordToken(tok) {
+			toks = append(toks, wrapToken{text: part, space: false})
+		}
+	}
+
+	return toks
+}
+
+// splitWordToken splits a single non-whitespace run into smaller runes at
+// the word-end boundaries matched by reWordEnd, e.g. "foo/bar" becomes
+// "foo/" and "bar". Runs with no such boundary (or only a trailing one) are
+// returned unsplit.
+func splitWordToken(rs []rune) [][]rune {
+	// reWordEnd must only ever see the visible runes of rs, never escape
+	// sequence bytes (their CSI parameters are often ASCII digits, which
+	// would otherwise be misread as word characters). So the match is run
+	// against a "visible" copy of rs, and matched byte offsets are mapped
+	// back to indices into rs through offsetIndex.
+	var visible strings.Builder
+	offsetIndex := map[int]int{} // rs index of the rune starting at each byte offset of `visible`
+
+	i := 0
+	for i < len(rs) {
+		if rs[i] == ansiEsc {
+			if n := escSeqLen(rs, i); n > 0 {
+				i += n
+				continue
+			}
+		}
+		offsetIndex[visible.Len()] = i
+		visible.WriteRune(rs[i])
+		i++
+	}
+	offsetIndex[visible.Len()] = len(rs)
+
+	s := visible.String()
+	locs := reWordEnd.FindAllStringIndex(s, -1)
+	if len(locs) == 0 {
+		return [][]rune{rs}
+	}
+
+	var parts [][]rune
+	prev := 0
+	for _, loc := range locs {
+		if loc[1] >= len(s) {
+			continue
+		}
+		end := offsetIndex[loc[1]]
+		if end > prev {
+			parts = append(parts, rs[prev:end])
+			prev = end
+		}
+	}
+	parts = append(parts, rs[prev:])
+
+	return parts
+}
+
+// wrapLines word-wraps s to width, returning the resulting lines. Wrapping
+// is rune-based and uses runewidth (via escAwareWidth) to measure display
+// width, so CJK and emoji are accounted for correctly. It prefers to break
+// at whitespace and at the word boundaries found by tokenizeWrapTokens, and
+// only hard-breaks a single token when it alone is wider than width.
+// Intentional blank lines (consecutive "\n") are preserved. ANSI escape
+// sequences are ignored for width accounting, kept in place in the output,
+// and the active SGR (color/style) state is re-emitted at the start of
+// every line a run of color is wrapped across.
+//
+// Meant to be consumed, together with alignLine and padBlock, by the
+// message popup, the error/echo lines and the preview pane; that wiring
+// lives outside this file and isn't part of this change.
+func wrapLines(s string, width int) []string {
+	if width <= 0 {
+		width = 1
+	}
+
+	var out []string
+	var sgrState []rune
+
+	for _, rawLine := range strings.Split(s, "\n") {
+		rs := []rune(rawLine)
+		if len(rs) == 0 {
+			out = append(out, "")
+			continue
+		}
+
+		cur := append([]rune{}, sgrState...)
+		curWidth := 0
+		wrapped := false
+
+		flush := func() {
+			out = append(out, string(cur))
+			cur = append([]rune{}, sgrState...)
+			curWidth = 0
+			wrapped = true
+		}
+
+		for _, tok := range tokenizeWrapTokens(rs) {
+			tw := escAwareWidth(tok.text)
+
+			if tok.space {
+				switch {
+				case curWidth == 0 && wrapped:
+					// drop leading whitespace that only exists because of
+					// a wrap break; whitespace actually present at the
+					// start of the original line is kept below.
+				case curWidth+tw > width:
+					flush()
+				default:
+					cur = append(cur, tok.text...)
+					curWidth += tw
+				}
+				sgrState = updateSGRState(sgrState, tok.text)
+				continue
+			}
+
+			if tw <= width {
+				if curWidth > 0 && curWidth+tw > width {
+					flush()
+				}
+				cur = append(cur, tok.text...)
+				curWidth += tw
+				sgrState = updateSGRState(sgrState, tok.text)
+				continue
+			}
+
+			// the token alone is wider than width: hard-break it
+			remaining := tok.text
+			for len(remaining) > 0 {
+				if curWidth >= width {
+					flush()
+				}
+
+				head, tail := escAwareSplitWidth(remaining, width-curWidth)
+				if len(head) == 0 && len(tail) == len(remaining) {
+					if curWidth > 0 {
+						// the next rune does not fit in what is left of
+						// this line; move it to a fresh one
+						flush()
+						continue
+					}
+					// a single rune is wider than width; force progress
+					head, tail = remaining[:1], remaining[1:]
+				}
+
+				cur = append(cur, head...)
+				curWidth += escAwareWidth(head)
+				// track the color of the runes actually consumed, not the
+				// synthetic reset escAwareSplitWidth may append to head
+				sgrState = updateSGRState(sgrState, remaining[:len(remaining)-len(tail)])
+				remaining = tail
+			}
+		}
+
+		out = append(out, string(cur))
+	}
+
+	return out
+}
+
+// Align selects how alignLine and padBlock position text within a field
+// that is wider than the text itself.
+type Align int
+
+const (
+	AlignLeft Align = iota
+	AlignRight
+	AlignCenter
+)
+
+// alignLine pads s with spaces so its visible width (escape sequences are
+// not counted) is at least width, positioning s according to align. s is
+// returned unchanged if it is already at least width wide.
+func alignLine(s string, width int, align Align) string {
+	w := escAwareWidth([]rune(s))
+	if w >= width {
+		return s
+	}
+
+	padding := width - w
+	switch align {
+	case AlignRight:
+		return strings.Repeat(" ", padding) + s
+	case AlignCenter:
+		left := padding / 2
+		return strings.Repeat(" ", left) + s + strings.Repeat(" ", padding-left)
+	default:
+		return s + strings.Repeat(" ", padding)
+	}
+}
+
+// padBlock aligns every line of lines to a common width, so the lines of a
+// multi-line UI region (e.g. a message popup) form a uniform block ready to
+// be drawn.
+func padBlock(lines []string, width int, align Align) []string {
+	out := make([]string, len(lines))
+	for i, line := range lines {
+		out[i] = alignLine(line, width, align)
+	}
+	return out
+}
+
 // This function is used to escape whitespaces and special characters with
 // backlashes in a given string.
 func escape(s string) string {
@@ -266,6 +739,49 @@ func humanize(size int64) string {
 	return ""
 }
 
+// This function is the 1024-based counterpart of humanize, used for the
+// "iec" mode of the humanize ruler template function. It reuses the same
+// rounding and suffix-stepping logic, but divides by 1024 and reports
+// binary suffixes (KiB, MiB, ...) instead of decimal ones.
+func humanizeIEC(size int64) string {
+	if size < 1024 {
+		return fmt.Sprintf("%dB", size)
+	}
+
+	suffix := []string{
+		"KiB",
+		"MiB",
+		"GiB",
+		"TiB",
+		"PiB",
+		"EiB",
+		"ZiB",
+		"YiB",
+	}
+
+	curr := float64(size) / 1024
+	for _, s := range suffix {
+		if curr < 10 {
+			return fmt.Sprintf("%.1f%s", curr-0.0499, s)
+		} else if curr < 1024 {
+			return fmt.Sprintf("%d%s", int(curr), s)
+		}
+		curr /= 1024
+	}
+
+	return ""
+}
+
+// humanizeSize dispatches to humanize or humanizeIEC depending on unit,
+// which is "si" (the default) for decimal suffixes or "iec" for 1024-based
+// ones.
+func humanizeSize(size int64, unit string) string {
+	if unit == "iec" {
+		return humanizeIEC(size)
+	}
+	return humanize(size)
+}
+
 // This function compares two strings for natural sorting which takes into
 // account values of numbers in strings. For example, '2' is less than '10',
 // and similarly 'foo2bar' is less than 'foo10bar', but 'bar2bar' is greater
@@ -307,6 +823,206 @@ func naturalLess(s1, s2 string) bool {
 	}
 }
 
+// Scoring constants for fuzzyScore, loosely modeled after the scheme used by
+// fuzzy finders like fzf: matches are worth a flat base score, with bonuses
+// for landing on word boundaries or right after a previous match, and a
+// penalty charged per candidate rune skipped between matches.
+const (
+	fuzzyNegInf = -(1 << 30)
+
+	fuzzyScoreMatch       = 16
+	fuzzyScoreConsecutive = 8
+	fuzzyScoreCaseExact   = 1
+
+	fuzzyBonusBoundary = 8
+	fuzzyBonusCamel    = 4
+
+	fuzzyGapPenalty      = 3
+	fuzzyGapPenaltyInner = 1
+)
+
+// This function reports whether the candidate rune at index j starts a new
+// "word" inside candidate, i.e. it is the first rune, follows one of
+// '/', '_', '-', '.', ' ', or is an upper case rune following a lower case
+// one (camelCase).
+func fuzzyIsBoundary(candidate []rune, j int) bool {
+	if j == 0 {
+		return true
+	}
+	switch candidate[j-1] {
+	case '/', '_', '-', '.', ' ':
+		return true
+	}
+	return unicode.IsLower(candidate[j-1]) && unicode.IsUpper(candidate[j])
+}
+
+func fuzzyBonusAt(candidate []rune, j int) int {
+	if !fuzzyIsBoundary(candidate, j) {
+		return 0
+	}
+	if j > 0 && unicode.IsLower(candidate[j-1]) && unicode.IsUpper(candidate[j]) {
+		return fuzzyBonusCamel
+	}
+	return fuzzyBonusBoundary
+}
+
+// A skipped candidate rune costs fuzzyGapPenalty when it starts a word after
+// one of '/', '_', '-', '.', ' ', and fuzzyGapPenaltyInner otherwise (camelCase
+// boundaries included). So when matching "ab", the gap "_x_" of "a_x_b",
+// which skips the word "x", costs more than the gap "xxx" of "axxxb".
+func fuzzyGapPenaltyAt(candidate []rune, j int) int {
+	if fuzzyBonusAt(candidate, j) >= fuzzyBonusBoundary {
+		return fuzzyGapPenalty
+	}
+	return fuzzyGapPenaltyInner
+}
+
+// fuzzyScore computes a fuzzy match of pattern against candidate using a
+// dynamic programming scheme over runes, with two tables tracked per pattern
+// rune i and candidate rune j: M[i][j] holds the best score of matching
+// pattern[:i+1] against a prefix of candidate that ends with a match at
+// candidate rune j, while D[i][j] holds the best score of matching
+// pattern[:i+1] against candidate[:j+1] with the last match strictly before
+// j, each rune skipped since then charged a gap penalty. The final score is the
+// best value of M[len(pattern)-1][*]; positions is recovered by backtracking
+// the choices recorded while filling the tables.
+//
+// matched is false, and score/positions are meaningless, if pattern cannot be
+// found as a (non-contiguous) subsequence of candidate. Matching is
+// case-insensitive, but exact-case matches are scored slightly higher.
+//
+// Meant to replace the substring/prefix matching used by the directory
+// listing sort and the `find` filter; that wiring lives outside this file
+// and isn't part of this change.
+func fuzzyScore(pattern, candidate string) (score int, matched bool, positions []int) {
+	p := []rune(pattern)
+	c := []rune(candidate)
+
+	if len(p) == 0 {
+		return 0, true, nil
+	}
+	if len(p) > len(c) {
+		return 0, false, nil
+	}
+
+	rows, cols := len(p), len(c)
+
+	bonus := make([]int, cols)
+	for j := 0; j < cols; j++ {
+		bonus[j] = fuzzyBonusAt(c, j)
+	}
+
+	M := make([][]int, rows)
+	D := make([][]int, rows)
+	mFromM := make([][]bool, rows)
+	dFromD := make([][]bool, rows)
+	for i := 0; i < rows; i++ {
+		M[i] = make([]int, cols)
+		D[i] = make([]int, cols)
+		mFromM[i] = make([]bool, cols)
+		dFromD[i] = make([]bool, cols)
+		for j := 0; j < cols; j++ {
+			M[i][j] = fuzzyNegInf
+			D[i][j] = fuzzyNegInf
+		}
+	}
+
+	for i := 0; i < rows; i++ {
+		for j := 0; j < cols; j++ {
+			if unicode.ToLower(p[i]) != unicode.ToLower(c[j]) {
+				continue
+			}
+
+			base := fuzzyScoreMatch + bonus[j]
+			if p[i] == c[j] {
+				base += fuzzyScoreCaseExact
+			}
+
+			if i == 0 {
+				M[i][j] = base
+				continue
+			}
+			if j == 0 {
+				continue
+			}
+
+			prevM, prevD := M[i-1][j-1], D[i-1][j-1]
+			if prevM == fuzzyNegInf && prevD == fuzzyNegInf {
+				continue
+			}
+
+			best := prevD
+			fromM := false
+			if prevM != fuzzyNegInf && prevM+fuzzyScoreConsecutive >= prevD {
+				best = prevM + fuzzyScoreConsecutive
+				fromM = true
+			}
+
+			M[i][j] = best + base
+			mFromM[i][j] = fromM
+		}
+
+		for j := 1; j < cols; j++ {
+			fromD, fromM := D[i][j-1], M[i][j-1]
+			best := fromM
+			useD := false
+			if fromD >= fromM {
+				best = fromD
+				useD = true
+			}
+			if best == fuzzyNegInf {
+				continue
+			}
+
+			D[i][j] = best - fuzzyGapPenaltyAt(c, j)
+			dFromD[i][j] = useD
+		}
+	}
+
+	last := rows - 1
+	bestJ := -1
+	for j := 0; j < cols; j++ {
+		if M[last][j] != fuzzyNegInf && (bestJ < 0 || M[last][j] > M[last][bestJ]) {
+			bestJ = j
+		}
+	}
+	if bestJ < 0 {
+		return 0, false, nil
+	}
+
+	positions = make([]int, rows)
+	i, j, inM := last, bestJ, true
+	for i >= 0 {
+		if inM {
+			positions[i] = j
+			if i == 0 {
+				break
+			}
+			inM = mFromM[i][j]
+			i, j = i-1, j-1
+		} else {
+			inM = !dFromD[i][j]
+			j--
+		}
+	}
+
+	return M[last][bestJ], true, positions
+}
+
+// fuzzyMatchLess reports whether, of two candidates that both matched the
+// same pattern with the given scores, cand1 should be ordered before cand2:
+// higher score first, ties broken by the shorter candidate, and remaining
+// ties broken with naturalLess.
+func fuzzyMatchLess(score1 int, cand1 string, score2 int, cand2 string) bool {
+	if score1 != score2 {
+		return score1 > score2
+	}
+	if len(cand1) != len(cand2) {
+		return len(cand1) < len(cand2)
+	}
+	return naturalLess(cand1, cand2)
+}
+
 // This function returns the extension of a file with a leading dot
 // it returns an empty string if extension could not be determined
 // i.e. directories, filenames without extensions
@@ -326,6 +1042,269 @@ var (
 	reRulerSub = regexp.MustCompile(`%[apmcsfithd]|%\{[^}]+\}`)
 )
 
+// rulerContext is the data made available to ruler format templates through
+// evalRulerTemplate. The single-letter fields mirror what
+// used to be available through the old %-style ruler codes (see
+// rulerLegacyFields); Selection, IsGitRepo and GitBranch are only reachable
+// through the new {{ }} template syntax.
+type rulerContext struct {
+	Acc      string // permission/access mode string of the current file
+	Progress int    // progress percentage of a long running job, or -1
+	Marks    int    // number of marked files
+	Copied   int    // number of files in the copy/cut buffer
+	Size     int64  // size of the current file, in bytes
+	Path     string // path of the current file
+	Ind      int    // index of the current file in the directory listing
+	Total    int    // total number of files in the directory listing
+	Hidden   int    // number of hidden files not shown in the listing
+
+	Mtime time.Time // modification time of the current file
+
+	Selection []string // paths currently selected
+	IsGitRepo bool     // whether the current directory is inside a git repo
+	GitBranch string   // name of the current git branch, if any
+}
+
+// rulerLegacyFields maps each single-letter code understood by reRulerSub to
+// the rulerContext field it used to read.
+var rulerLegacyFields = map[byte]string{
+	'a': "Acc",
+	'p': "Progress",
+	'm': "Marks",
+	'c': "Copied",
+	's': "Size",
+	'f': "Path",
+	'i': "Ind",
+	't': "Total",
+	'h': "Hidden",
+	'd': "Mtime",
+}
+
+// compileLegacyRulerFormat translates a ruler format string written in the
+// old %-style syntax (the one matched by reRulerSub) into an equivalent
+// text/template source string, so that existing user configs keep working
+// unmodified once loaded through rulerTemplate. Sequences not recognized by
+// reRulerSub are left untouched. The %-codes are rewritten even in a format
+// that also uses the new {{ }} syntax; rulerTemplate skips this translation
+// for such formats.
+func compileLegacyRulerFormat(format string) string {
+	return reRulerSub.ReplaceAllStringFunc(format, func(tok string) string {
+		if strings.HasPrefix(tok, "%{") {
+			return fmt.Sprintf("{{env %q}}", tok[2:len(tok)-1])
+		}
+		if field, ok := rulerLegacyFields[tok[1]]; ok {
+			return fmt.Sprintf("{{.%s}}", field)
+		}
+		return tok
+	})
+}
+
+func rulerTrunc(width int, s string) string {
+	rs := []rune(s)
+	if runeSliceWidth(rs) <= width {
+		return s
+	}
+	return string(runeSliceWidthRange(rs, 0, width))
+}
+
+func rulerPad(width int, s string) string {
+	w := runeSliceWidth([]rune(s))
+	if w >= width {
+		return s
+	}
+	return s + strings.Repeat(" ", width-w)
+}
+
+func rulerRel(base, target string) string {
+	rel, err := filepath.Rel(base, target)
+	if err != nil {
+		return target
+	}
+	return rel
+}
+
+// rulerToInt64 coerces any of the integer- or float-kinded values a ruler
+// template might hand to an arithmetic helper (e.g. an int field of
+// rulerContext, or an int64 produced by another helper) into an int64,
+// following the same reflection-based approach as rulerFirst/rulerLen so
+// the arithmetic helpers aren't tied to one specific Go integer type.
+func rulerToInt64(v interface{}) int64 {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(rv.Uint())
+	case reflect.Float32, reflect.Float64:
+		return int64(rv.Float())
+	default:
+		return 0
+	}
+}
+
+func rulerAdd(a, b interface{}) int64 {
+	return rulerToInt64(a) + rulerToInt64(b)
+}
+
+func rulerSub(a, b interface{}) int64 {
+	return rulerToInt64(a) - rulerToInt64(b)
+}
+
+func rulerMul(a, b interface{}) int64 {
+	return rulerToInt64(a) * rulerToInt64(b)
+}
+
+func rulerDiv(a, b interface{}) int64 {
+	bb := rulerToInt64(b)
+	if bb == 0 {
+		return 0
+	}
+	return rulerToInt64(a) / bb
+}
+
+func rulerMod(a, b interface{}) int64 {
+	bb := rulerToInt64(b)
+	if bb == 0 {
+		return 0
+	}
+	return rulerToInt64(a) % bb
+}
+
+// rulerFirst, rulerLast and rulerSlice operate on any slice or array value
+// (e.g. the []string held by rulerContext.Selection) via reflection, so the
+// same helpers work for every collection exposed to a ruler template.
+func rulerFirst(v interface{}) interface{} {
+	rv := reflect.ValueOf(v)
+	if (rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array) || rv.Len() == 0 {
+		return nil
+	}
+	return rv.Index(0).Interface()
+}
+
+func rulerLast(v interface{}) interface{} {
+	rv := reflect.ValueOf(v)
+	if (rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array) || rv.Len() == 0 {
+		return nil
+	}
+	return rv.Index(rv.Len() - 1).Interface()
+}
+
+func rulerSlice(beg, end int, v interface{}) interface{} {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return v
+	}
+	if beg < 0 {
+		beg = 0
+	}
+	if end > rv.Len() {
+		end = rv.Len()
+	}
+	if beg > end {
+		beg = end
+	}
+	return rv.Slice(beg, end).Interface()
+}
+
+func rulerLen(v interface{}) int {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map, reflect.String, reflect.Chan:
+		return rv.Len()
+	default:
+		return 0
+	}
+}
+
+// rulerFuncMap is the curated function map that rulerTemplate installs for
+// ruler format templates.
+func rulerFuncMap() template.FuncMap {
+	return template.FuncMap{
+		// string helpers
+		"upper":     strings.ToUpper,
+		"lower":     strings.ToLower,
+		"title":     rulerTitle,
+		"trim":      strings.TrimSpace,
+		"trunc":     rulerTrunc,
+		"pad":       rulerPad,
+		"repeat":    func(n int, s string) string { return strings.Repeat(s, n) },
+		"replace":   func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+		"split":     func(sep, s string) []string { return strings.Split(s, sep) },
+		"join":      func(sep string, parts []string) string { return strings.Join(parts, sep) },
+		"contains":  func(substr, s string) bool { return strings.Contains(s, substr) },
+		"hasPrefix": func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+
+		// path helpers
+		"basename": filepath.Base,
+		"dirname":  filepath.Dir,
+		"ext":      filepath.Ext,
+		"rel":      rulerRel,
+
+		// numeric helpers
+		"add": rulerAdd,
+		"sub": rulerSub,
+		"mul": rulerMul,
+		"div": rulerDiv,
+		"mod": rulerMod,
+
+		// collection helpers
+		"first": rulerFirst,
+		"last":  rulerLast,
+		"slice": rulerSlice,
+		"len":   rulerLen,
+
+		// date and size formatting
+		"date":     func(layout string, t time.Time) string { return t.Format(layout) },
+		"humanize": func(unit string, size int64) string { return humanizeSize(size, unit) },
+
+		// environment lookup, used to translate the legacy %{env} syntax
+		"env": os.Getenv,
+	}
+}
+
+func rulerTitle(s string) string {
+	words := strings.Fields(s)
+	for i, w := range words {
+		r := []rune(w)
+		r[0] = unicode.ToUpper(r[0])
+		words[i] = string(r)
+	}
+	return strings.Join(words, " ")
+}
+
+// rulerTemplate parses format into a ready to execute *template.Template.
+// format may either be written in the new {{ }} template syntax, or in the
+// legacy %-style syntax, which is transparently translated first by
+// compileLegacyRulerFormat.
+func rulerTemplate(name, format string) (*template.Template, error) {
+	if !strings.Contains(format, "{{") {
+		format = compileLegacyRulerFormat(format)
+	}
+	return template.New(name).Funcs(rulerFuncMap()).Parse(format)
+}
+
+// evalRulerTemplate renders format (legacy %-style syntax or a {{ }}
+// template) against data, which is typically a *rulerContext, and returns
+// the resulting string. Besides the fixed set of %-codes matched by
+// reRulerSub, it accepts full {{ }} pipelines using rulerFuncMap.
+//
+// Meant to be called from the ruler-drawing code with a *rulerContext built
+// from the current app state, and exposed to the `cmd`-language for dynamic
+// prompts and status lines; both call sites live outside this file and
+// aren't part of this change.
+func evalRulerTemplate(name, format string, data interface{}) (string, error) {
+	tmpl, err := rulerTemplate(name, format)
+	if err != nil {
+		return "", err
+	}
+
+	var buf strings.Builder
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
 var (
 	reWord    = regexp.MustCompile(`(\pL|\pN)+`)
 	reWordBeg = regexp.MustCompile(`([^\pL\pN]|^)(\pL|\pN)`)
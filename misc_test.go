@@ -0,0 +1,253 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode"
+)
+
+func TestFuzzyScoreEmptyPattern(t *testing.T) {
+	score, matched, positions := fuzzyScore("", "anything")
+	if !matched || score != 0 || positions != nil {
+		t.Fatalf("empty pattern should match trivially with no positions, got score=%d matched=%v positions=%v", score, matched, positions)
+	}
+}
+
+func TestFuzzyScoreAllMismatch(t *testing.T) {
+	if _, matched, _ := fuzzyScore("xyz", "abcdef"); matched {
+		t.Fatalf("pattern runes absent from candidate should not match")
+	}
+}
+
+func TestFuzzyScoreOutOfOrder(t *testing.T) {
+	// "ab" only occurs in "ba" out of order: 'b' comes before 'a', not
+	// 'a' before 'b' as the pattern requires.
+	if _, matched, _ := fuzzyScore("ab", "ba"); matched {
+		t.Fatalf("pattern runes out of order should not match")
+	}
+}
+
+func TestFuzzyScorePositions(t *testing.T) {
+	score, matched, positions := fuzzyScore("ab", "xaxb")
+	if !matched {
+		t.Fatalf("expected match")
+	}
+	if len(positions) != 2 || positions[0] != 1 || positions[1] != 3 {
+		t.Fatalf("unexpected positions %v (score %d)", positions, score)
+	}
+}
+
+func TestFuzzyScoreConsecutiveBonus(t *testing.T) {
+	consecutive, matched, _ := fuzzyScore("ab", "ab")
+	if !matched {
+		t.Fatalf("expected match")
+	}
+	gapped, matched, _ := fuzzyScore("ab", "axb")
+	if !matched {
+		t.Fatalf("expected match")
+	}
+	if consecutive <= gapped {
+		t.Fatalf("consecutive match (score %d) should score higher than a gapped match (score %d)", consecutive, gapped)
+	}
+}
+
+func TestFuzzyScoreHugeCandidate(t *testing.T) {
+	candidate := strings.Repeat("x", 5000) + "needle"
+	score, matched, positions := fuzzyScore("needle", candidate)
+	if !matched {
+		t.Fatalf("expected match in huge candidate")
+	}
+	if len(positions) != len("needle") || positions[0] != 5000 {
+		t.Fatalf("unexpected positions %v (score %d)", positions, score)
+	}
+}
+
+func TestFuzzyMatchLess(t *testing.T) {
+	if !fuzzyMatchLess(10, "a", 5, "b") {
+		t.Fatalf("higher score should sort first")
+	}
+	if fuzzyMatchLess(5, "b", 10, "a") {
+		t.Fatalf("lower score should not sort first")
+	}
+	if !fuzzyMatchLess(5, "ab", 5, "abc") {
+		t.Fatalf("on tied score, shorter candidate should sort first")
+	}
+	if !fuzzyMatchLess(5, "abc", 5, "abd") {
+		t.Fatalf("on tied score and length, naturalLess should break the tie")
+	}
+}
+
+// stripSpaceRunes removes every whitespace rune, so wrapped output can be
+// compared against its source without caring which whitespace run a break
+// happened to land on.
+func stripSpaceRunes(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+func TestWrapLinesRespectsWidth(t *testing.T) {
+	lines := wrapLines("the quick brown fox jumps over the lazy dog", 10)
+	for _, l := range lines {
+		if w := escAwareWidth([]rune(l)); w > 10 {
+			t.Fatalf("line %q exceeds width 10 (w=%d)", l, w)
+		}
+	}
+}
+
+func TestWrapLinesPreservesOriginalIndentation(t *testing.T) {
+	lines := wrapLines("    indented\n  second", 40)
+	if len(lines) != 2 || lines[0] != "    indented" || lines[1] != "  second" {
+		t.Fatalf("leading whitespace of the original lines should be kept, got %#v", lines)
+	}
+}
+
+func TestWrapLinesPreservesBlankLines(t *testing.T) {
+	lines := wrapLines("first\n\nthird", 20)
+	if len(lines) != 3 || lines[1] != "" {
+		t.Fatalf("intentional blank line should be preserved, got %#v", lines)
+	}
+}
+
+func TestWrapLinesCJK(t *testing.T) {
+	s := "中文测试こんにちは世界hello"
+	lines := wrapLines(s, 6)
+	for _, l := range lines {
+		if w := escAwareWidth([]rune(l)); w > 6 {
+			t.Fatalf("CJK line %q exceeds width 6 (w=%d)", l, w)
+		}
+	}
+	if got := strings.Join(lines, ""); got != s {
+		t.Fatalf("wrapping CJK text should not drop or reorder glyphs, got %q want %q", got, s)
+	}
+}
+
+func TestWrapLinesCombiningMarks(t *testing.T) {
+	// "e" followed by COMBINING ACUTE ACCENT (U+0301) must stay attached to
+	// its base rune rather than being split onto its own line or dropped.
+	s := "café au lait"
+	lines := wrapLines(s, 6)
+	if got, want := stripSpaceRunes(strings.Join(lines, "")), stripSpaceRunes(s); got != want {
+		t.Fatalf("combining mark was dropped or reordered: lines=%#v got=%q want=%q", lines, got, want)
+	}
+}
+
+func TestWrapLinesTabs(t *testing.T) {
+	s := "a\tb\tc\td\te"
+	lines := wrapLines(s, 4)
+	if got, want := stripSpaceRunes(strings.Join(lines, "")), stripSpaceRunes(s); got != want {
+		t.Fatalf("tab-separated content altered: lines=%#v got=%q want=%q", lines, got, want)
+	}
+}
+
+func TestWrapLinesHardBreaksOverWidthGlyph(t *testing.T) {
+	// Each CJK glyph is two columns wide, so at width 1 none of them fit;
+	// wrapLines must still make forward progress instead of looping.
+	lines := wrapLines("中中中", 1)
+	if len(lines) != 3 {
+		t.Fatalf("expected each over-width glyph forced onto its own line, got %#v", lines)
+	}
+}
+
+func TestWrapLinesKeepsANSISequencesIntact(t *testing.T) {
+	colored := "\x1b[31mredredred redredred\x1b[0m plain"
+	for _, l := range wrapLines(colored, 10) {
+		rs := []rune(l)
+		for i := 0; i < len(rs); {
+			if rs[i] != ansiEsc {
+				i++
+				continue
+			}
+			n := escSeqLen(rs, i)
+			// every sequence in the input is a complete SGR; a split one
+			// would lose its final 'm' on the line it starts on
+			if n == 0 || i+n > len(rs) || !escIsSGR(rs, i, i+n) {
+				t.Fatalf("escape sequence split or truncated across a wrapped line: %q", l)
+			}
+			i += n
+		}
+	}
+}
+
+func TestEscAwareWidthSkipsEscapes(t *testing.T) {
+	if w := escAwareWidth([]rune("\x1b[31mhi\x1b[0m")); w != 2 {
+		t.Fatalf("escape sequences should not count towards width, got %d", w)
+	}
+}
+
+func TestEscAwareSliceRangePreservesLeadingColor(t *testing.T) {
+	// beg lands after the opening SGR sequence; it must be re-emitted at
+	// the front of the result or the cut text renders uncolored.
+	got := string(escAwareSliceRange([]rune("\x1b[31mabcdef"), 2, 4))
+	if want := "\x1b[31mcd\x1b[0m"; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+}
+
+func TestEscAwareSliceRangeNoResetWithoutColor(t *testing.T) {
+	if got := string(escAwareSliceRange([]rune("abcdef"), 2, 4)); got != "cd" {
+		t.Fatalf("uncolored input should not gain escape sequences, got %q", got)
+	}
+}
+
+func TestEscAwareSliceRangeResetBeforeBegClearsColor(t *testing.T) {
+	// The color is switched off before beg, so it must not leak into the
+	// kept range even though an SGR sequence appeared earlier in rs.
+	got := string(escAwareSliceRange([]rune("\x1b[31mab\x1b[0mcdef"), 2, 4))
+	if want := "cd"; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+}
+
+func TestEscAwareSliceRangeOutOfRange(t *testing.T) {
+	if got := escAwareSliceRange([]rune("abc"), 10, 12); len(got) != 0 {
+		t.Fatalf("beg past the visible width should return no runes, got %q", string(got))
+	}
+}
+
+func TestEscAwareTruncatePreservesLeadingColor(t *testing.T) {
+	got := string(escAwareTruncate([]rune("\x1b[31mhello"), 3))
+	if want := "\x1b[31mhel\x1b[0m"; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+}
+
+func TestAlignLine(t *testing.T) {
+	if got := alignLine("hi", 6, AlignLeft); got != "hi    " {
+		t.Fatalf("left align: got %q", got)
+	}
+	if got := alignLine("hi", 6, AlignRight); got != "    hi" {
+		t.Fatalf("right align: got %q", got)
+	}
+	if got := alignLine("hi", 6, AlignCenter); got != "  hi  " {
+		t.Fatalf("center align: got %q", got)
+	}
+	if got := alignLine("unchanged", 3, AlignLeft); got != "unchanged" {
+		t.Fatalf("a line already at or above width should be returned unchanged, got %q", got)
+	}
+}
+
+func TestPadBlock(t *testing.T) {
+	for _, l := range padBlock([]string{"a", "bb", "ccc"}, 5, AlignRight) {
+		if w := escAwareWidth([]rune(l)); w != 5 {
+			t.Fatalf("padBlock line %q has width %d, want 5", l, w)
+		}
+	}
+}
+
+func TestRulerArithmeticAcceptsIntFields(t *testing.T) {
+	// Progress, Ind and Total are plain int fields on rulerContext, not
+	// int64; the numeric template helpers must accept them directly
+	// instead of erroring out with a text/template type mismatch.
+	ctx := &rulerContext{Ind: 4, Total: 10, Progress: 50}
+	got, err := evalRulerTemplate("test", "{{add .Ind 1}}/{{.Total}} {{div .Progress 2}}%", ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := "5/10 25%"; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+}